@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+// backoff produces a bounded, doubling sequence of durations. It's used
+// whenever we have to wait for a file that disappeared (most often
+// mid log-rotation) to come back, so callers don't hammer the
+// filesystem with a tight loop nor sit on a single fixed interval
+// regardless of how long the file has been gone.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+// newBackoff returns a backoff starting at initial and doubling on
+// every call to next() up to max.
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{initial: initial, max: max, current: initial}
+}
+
+// next returns the duration to wait before the next retry and advances
+// the sequence.
+func (b *backoff) next() time.Duration {
+	d := b.current
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return d
+}
+
+// reset restarts the sequence at its initial duration.
+func (b *backoff) reset() {
+	b.current = b.initial
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// registry fans watcher events out to each file's own tailer goroutine
+// by path, and tracks glob-watched directories so newly created files
+// matching a pattern can be picked up and tailed on the fly.
+type registry struct {
+	mu       sync.Mutex
+	files    map[string]chan Event
+	dirs     []*watchedDir
+	maxFiles int
+	count    int
+}
+
+// watchedDir is a directory being watched so that files created in it
+// after startup and matching validator can be discovered dynamically.
+type watchedDir struct {
+	validator *Validator
+	seen      map[string]bool
+}
+
+func newRegistry(maxFiles int) *registry {
+	return &registry{
+		files:    make(map[string]chan Event),
+		maxFiles: maxFiles,
+	}
+}
+
+// register reserves a slot for fname and returns the channel its
+// tailer goroutine should read events from. ok is false if maxFiles
+// would be exceeded.
+func (r *registry) register(fname string) (ch chan Event, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, already := r.files[fname]; already {
+		return nil, false
+	}
+
+	if r.count >= r.maxFiles {
+		return nil, false
+	}
+
+	ch = make(chan Event, 8)
+	r.files[fname] = ch
+	r.count++
+
+	return ch, true
+}
+
+func (r *registry) watchDir(v *Validator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirs = append(r.dirs, &watchedDir{validator: v, seen: make(map[string]bool)})
+}
+
+// dispatch runs until done is closed, forwarding watcher events to the
+// registered file matching their Name, or spawning a new tailer when a
+// watched directory reports a file matching one of its glob patterns.
+func (r *registry) dispatch(watcher Watcher, spawn func(fname string), done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-watcher.Errors():
+			printErr(fmt.Sprintf("watcher error: %s", err))
+		case event := <-watcher.Events():
+			r.mu.Lock()
+			ch, tracked := r.files[event.Name]
+			r.mu.Unlock()
+
+			if tracked {
+				select {
+				case ch <- event:
+				default:
+					debug(fmt.Sprintf("dropping event for %s, consumer is behind", event.Name))
+				}
+				continue
+			}
+
+			if event.Op == Create || event.Op == Rename {
+				if r.maybeWatchSubdir(event.Name, watcher, spawn) {
+					continue
+				}
+				r.maybeDiscover(event.Name, spawn)
+			}
+		}
+	}
+}
+
+// maybeWatchSubdir reports whether path is a directory, and if so
+// handles it: a directory created under a recursively ("**") watched
+// base needs its own watch registered too, since backends don't
+// recurse on their own, plus a walk for any files that may have been
+// created in it before the watch was added. Non-directories return
+// false so the caller falls through to maybeDiscover.
+func (r *registry) maybeWatchSubdir(path string, watcher Watcher, spawn func(fname string)) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	r.mu.Lock()
+	var match *watchedDir
+	for _, d := range r.dirs {
+		if !d.validator.Recursive() {
+			continue
+		}
+		rel, err := filepath.Rel(d.validator.Base(), path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		match = d
+		break
+	}
+	r.mu.Unlock()
+
+	if match == nil {
+		return true
+	}
+
+	if err := watcher.Add(path); err != nil {
+		printErr(fmt.Sprintf("error while watching %s for new files: %s", path, err))
+		return true
+	}
+	debug(fmt.Sprintf("watching newly created subdirectory: %s", path))
+
+	_ = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		r.maybeDiscover(p, spawn)
+		return nil
+	})
+
+	return true
+}
+
+// maybeDiscover checks a newly seen path against every watched
+// directory's validator, spawning a tailer for the first match.
+func (r *registry) maybeDiscover(path string, spawn func(fname string)) {
+	r.mu.Lock()
+	var match *watchedDir
+	for _, d := range r.dirs {
+		if filepath.Dir(path) != d.validator.Base() && !d.validator.Recursive() {
+			continue
+		}
+		if d.seen[path] {
+			continue
+		}
+		if d.validator.Match(path) {
+			d.seen[path] = true
+			match = d
+			break
+		}
+	}
+	full := r.count >= r.maxFiles
+	r.mu.Unlock()
+
+	if match == nil {
+		return
+	}
+
+	if full {
+		debug(fmt.Sprintf("ignoring newly discovered file %s, at --max-files limit", path))
+		return
+	}
+
+	debug(fmt.Sprintf("discovered new file matching pattern: %s", path))
+	spawn(path)
+}
+
+// discoverGlob expands pattern into the files it currently matches and
+// returns a Validator for watching its base directory for files
+// created afterwards.
+func discoverGlob(pattern string, recursive bool) ([]string, *Validator, error) {
+	v, err := NewValidator(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var matches []string
+	walkErr := filepath.Walk(v.Base(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != v.Base() && !recursive && !v.Recursive() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if v.Match(path) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return nil, nil, walkErr
+	}
+
+	return matches, v, nil
+}
+
+// watchSubdirs walks base and adds a watch for every subdirectory
+// found under it, so a "**" pattern's recursive discovery also
+// notices files created in directories that already existed at
+// startup. Directories created later are picked up dynamically by
+// registry.maybeWatchSubdir.
+func watchSubdirs(watcher Watcher, base string) {
+	_ = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == base {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			printErr(fmt.Sprintf("error while watching %s for new files: %s", path, err))
+		}
+		return nil
+	})
+}
+
+// tailFile opens fname, prints its initial tail, and then consumes
+// events from its registry channel until it's told to shut down,
+// reopening the file across rotations per follow. If rl is non-nil,
+// output for this file is throttled through its leaky bucket before
+// being printed.
+func tailFile(watcher Watcher, ch chan Event, fname string, lcount int, fmtr Formatter, follow followMode, reopenDeadline time.Duration, rl *rateLimiter, done chan bool) {
+	f, err := os.Open(fname)
+	handleErrorAndExit(err, fmt.Sprintf("error while opening file: %s", fname))
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+
+	// emit prints content read from the file, ending at fsize, after
+	// passing it through the formatter and rate limiter. Formatting
+	// happens first so offsets (e.g. in --output=json) are computed
+	// from the real bytes read, rather than from whatever's left
+	// after the rate limiter has truncated lines away; the limiter
+	// then only throttles the already-offset-stamped output for
+	// display.
+	emit := func(content string, fsize int64) {
+		if content == "" {
+			return
+		}
+		startOffset := fsize - int64(len(content))
+		formatted := fmtr.Format(f.Name(), startOffset, content)
+		if rl != nil {
+			formatted = rl.throttle(formatted)
+		}
+		printContent(formatted)
+	}
+
+	seekBackwardsByLineCount(lcount, f)
+	content, lastFSize := readContentToEOF(f)
+	emit(content, lastFSize)
+
+	var lastModTime time.Time
+	if finfo, err := os.Stat(f.Name()); err == nil {
+		lastModTime = finfo.ModTime()
+	}
+
+	// reopen follows f's path by name after it's gone missing, per
+	// follow. It's shared by the Rename/Remove case and by a Chmod
+	// that turns out to be fsnotify's IN_ATTRIB-for-unlink (exactly
+	// what a plain `rm` of the tailed file produces), since both mean
+	// the same thing: the path we had open is gone.
+	// Returns false if the caller should give up and stop tailing.
+	reopen := func() bool {
+		if follow == followDescriptor {
+			debug("follow=descriptor, not reopening, stopping")
+			return false
+		}
+
+		_ = f.Close()
+
+		nf, err := reopenByName(f.Name(), reopenDeadline)
+		if err != nil {
+			printErr(fmt.Sprintf("giving up on %s: %s", f.Name(), err))
+			return false
+		}
+		f = nf
+
+		if err := watcher.Add(f.Name()); err != nil {
+			handleErrorAndExit(err, fmt.Sprintf("error while adding watch for: %s", f.Name()))
+		}
+
+		content, rsize := readContentToEOF(f)
+		lastFSize = rsize
+		if finfo, err := os.Stat(f.Name()); err == nil {
+			lastModTime = finfo.ModTime()
+		}
+		emit(content, lastFSize)
+		return true
+	}
+
+ConLoop:
+	for {
+		select {
+		case <-done:
+			debug("received notice to shutdown")
+			break ConLoop
+		case event := <-ch:
+			switch event.Op {
+			case Rename, Remove:
+				debug("FILE MOVED OR REMOVED")
+				if !reopen() {
+					break ConLoop
+				}
+			case Write:
+				content, rsize, mtime, err := handleWrite(f, lastFSize, lastModTime)
+				handleErrorAndExit(err, "error while handling write event")
+				lastFSize = rsize
+				lastModTime = mtime
+				emit(content, lastFSize)
+			case Chmod:
+				debug("CHMOD received")
+				if _, err := os.Stat(f.Name()); err != nil {
+					debug("FILE DELETED (reported via CHMOD), reopening per follow mode")
+					if !reopen() {
+						break ConLoop
+					}
+				}
+			}
+		}
+	}
+}
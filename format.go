@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formatter renders a chunk of newly read lines from one file as the
+// text written to stdout. text is the default, human-oriented format
+// matching tailf's original behavior; json emits NDJSON for machine
+// consumption. Future formats (logfmt, CBOR) can be added by
+// implementing this interface.
+type Formatter interface {
+	// Format renders content (one or more newline-terminated lines)
+	// read from fname, where startOffset is the byte offset within
+	// fname at which content began.
+	Format(fname string, startOffset int64, content string) string
+}
+
+// parseFormatter parses a --output=<name> value into a Formatter.
+func parseFormatter(s string, multiName bool) (Formatter, error) {
+	switch s {
+	case "", "text":
+		return textFormatter{multiName: multiName}, nil
+	case "json":
+		return jsonFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown --output format: %s", s)
+	}
+}
+
+// textFormatter is tailf's original human-oriented format: lines are
+// printed as-is, prefixed with the file's base name in bold when more
+// than one file is being tailed.
+type textFormatter struct {
+	multiName bool
+}
+
+func (tf textFormatter) Format(fname string, startOffset int64, content string) string {
+	if !tf.multiName {
+		return content
+	}
+
+	bfn := fmt.Sprintf("\x1b[1m%s => \x1b[0m", filepath.Base(fname))
+
+	var b strings.Builder
+	for _, l := range splitLines(content) {
+		b.WriteString(fmt.Sprintf("%s %s\n", bfn, l))
+	}
+	return b.String()
+}
+
+// jsonFormatter emits one NDJSON object per line, making tailf's
+// output composable with downstream log shippers and
+// prometheus/loki-style scrapers.
+type jsonFormatter struct{}
+
+type jsonLine struct {
+	File   string `json:"file"`
+	Ts     string `json:"ts"`
+	Line   string `json:"line"`
+	Offset int64  `json:"offset"`
+}
+
+func (jsonFormatter) Format(fname string, startOffset int64, content string) string {
+	offset := startOffset
+
+	var b strings.Builder
+	for _, l := range splitLines(content) {
+		offset += int64(len(l)) + 1 // +1 accounts for the line's newline
+
+		enc, err := json.Marshal(jsonLine{
+			File:   fname,
+			Ts:     time.Now().Format(time.RFC3339Nano),
+			Line:   l,
+			Offset: offset,
+		})
+		if err != nil {
+			printErr(fmt.Sprintf("error while encoding json line: %s", err))
+			continue
+		}
+
+		b.Write(enc)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// splitLines splits content, as returned by readContentToEOF, into
+// its individual lines.
+func splitLines(content string) []string {
+	return strings.Split(strings.TrimRight(content, "\n"), "\n")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Validator splits a glob pattern like "/var/log/*.log" or
+// "/var/log/**/*.err" into a base directory that can be watched for
+// changes and a predicate that decides whether a given path found
+// under that directory matches the pattern. Modeled on grok_exporter's
+// glob.Validator.
+type Validator struct {
+	base      string
+	patterns  []string
+	recursive bool
+}
+
+// NewValidator parses pattern into a watchable base directory and a
+// match predicate. A "{a,b}" brace group in pattern is expanded into
+// one match pattern per alternative, since filepath.Match doesn't
+// implement brace alternation itself.
+func NewValidator(pattern string) (*Validator, error) {
+	abs, err := filepath.Abs(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	base, rest := splitGlobBase(abs)
+
+	patterns, err := expandBraces(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	// validate every alternative early so a typo is reported
+	// immediately rather than silently matching nothing
+	for _, p := range patterns {
+		if _, err := filepath.Match(strings.ReplaceAll(p, "**", "*"), p); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Validator{
+		base:      base,
+		patterns:  patterns,
+		recursive: strings.Contains(rest, "**"),
+	}, nil
+}
+
+// expandBraces expands the first "{a,b,c}" group found in pattern
+// into one pattern per alternative, recursively expanding any
+// remaining groups in each result. A pattern with no brace group is
+// returned unchanged. Nesting braces isn't supported, matching this
+// package's simple glob dialect rather than full shell expansion.
+func expandBraces(pattern string) ([]string, error) {
+	start := strings.IndexByte(pattern, '{')
+	if start == -1 {
+		return []string{pattern}, nil
+	}
+
+	end := strings.IndexByte(pattern[start:], '}')
+	if end == -1 {
+		return nil, fmt.Errorf("unterminated brace in pattern: %s", pattern)
+	}
+	end += start
+
+	group := pattern[start+1 : end]
+	if strings.ContainsRune(group, '{') {
+		return nil, fmt.Errorf("nested braces are not supported: %s", pattern)
+	}
+
+	prefix, suffix := pattern[:start], pattern[end+1:]
+
+	var out []string
+	for _, alt := range strings.Split(group, ",") {
+		expanded, err := expandBraces(prefix + alt + suffix)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+
+	return out, nil
+}
+
+// splitGlobBase walks path's components until it finds the first one
+// containing a glob metacharacter, returning everything before it as
+// the base directory to watch and everything from that point on as
+// the match pattern, relative to base.
+func splitGlobBase(path string) (base, rest string) {
+	parts := strings.Split(path, string(filepath.Separator))
+
+	i := 0
+	for ; i < len(parts); i++ {
+		if containsGlobMeta(parts[i]) {
+			break
+		}
+	}
+
+	base = strings.Join(parts[:i], string(filepath.Separator))
+	if base == "" {
+		base = string(filepath.Separator)
+	}
+	rest = strings.Join(parts[i:], string(filepath.Separator))
+
+	return base, rest
+}
+
+func containsGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[{")
+}
+
+// IsGlob reports whether s contains a glob metacharacter, i.e. whether
+// it needs expanding rather than being treated as a literal path.
+func IsGlob(s string) bool {
+	return containsGlobMeta(s)
+}
+
+// Base returns the directory that should be watched for new files.
+func (v *Validator) Base() string {
+	return v.base
+}
+
+// Recursive reports whether the pattern requires watching
+// subdirectories of Base (a "**" component was used).
+func (v *Validator) Recursive() bool {
+	return v.recursive
+}
+
+// Match reports whether an absolute path found under Base matches the
+// pattern, i.e. any one of its brace-expanded alternatives.
+func (v *Validator) Match(path string) bool {
+	rel, err := filepath.Rel(v.base, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	for _, pattern := range v.patterns {
+		if v.recursive {
+			// "**" matches any number of intermediate directories, so
+			// just match the filename against the pattern's final
+			// segment
+			if matched, _ := filepath.Match(filepath.Base(pattern), filepath.Base(path)); matched {
+				return true
+			}
+			continue
+		}
+
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestValidator_MatchBraces(t *testing.T) {
+	v, err := NewValidator("/var/log/*.{log,err}")
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+
+	cases := map[string]bool{
+		"/var/log/app.log":  true,
+		"/var/log/app.err":  true,
+		"/var/log/app.txt":  false,
+		"/var/log/app.logs": false,
+	}
+	for path, want := range cases {
+		if got := v.Match(path); got != want {
+			t.Errorf("Match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestValidator_MatchBracesRecursive(t *testing.T) {
+	v, err := NewValidator("/var/log/**/*.{log,err}")
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	if !v.Recursive() {
+		t.Fatal("expected Recursive() to be true for a \"**\" pattern")
+	}
+
+	if !v.Match("/var/log/nested/deep/app.err") {
+		t.Error("expected a nested .err file to match")
+	}
+	if v.Match("/var/log/nested/deep/app.txt") {
+		t.Error("expected a nested .txt file not to match")
+	}
+}
+
+func TestValidator_UnterminatedBrace(t *testing.T) {
+	if _, err := NewValidator("/var/log/*.{log,err"); err == nil {
+		t.Fatal("expected an error for an unterminated brace group")
+	}
+}
+
+func TestValidator_NestedBraces(t *testing.T) {
+	if _, err := NewValidator("/var/log/*.{a,{b,c}}"); err == nil {
+		t.Fatal("expected an error for a nested brace group")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rateLimiter throttles how many lines a single file may emit per
+// second using a leaky bucket, so a file that suddenly writes far
+// faster than a terminal can usefully show doesn't flood the console.
+// Modeled on hpcloud/tail's ratelimiter.
+type rateLimiter struct {
+	linesPerSec float64
+	burst       int
+
+	level    float64
+	lastLeak time.Time
+}
+
+// newRateLimiter returns a limiter allowing linesPerSec lines per
+// second on average, with bursts up to burst lines.
+func newRateLimiter(linesPerSec float64, burst int) *rateLimiter {
+	return &rateLimiter{linesPerSec: linesPerSec, burst: burst, lastLeak: time.Now()}
+}
+
+// allow reports how many of n pending lines may pass through right
+// now, leaking the bucket down by however much time has passed since
+// the last call first.
+func (rl *rateLimiter) allow(n int) int {
+	now := time.Now()
+	rl.level -= now.Sub(rl.lastLeak).Seconds() * rl.linesPerSec
+	if rl.level < 0 {
+		rl.level = 0
+	}
+	rl.lastLeak = now
+
+	capacity := float64(rl.burst) - rl.level
+	if capacity <= 0 {
+		return 0
+	}
+
+	allowed := n
+	if float64(allowed) > capacity {
+		allowed = int(capacity)
+	}
+
+	rl.level += float64(allowed)
+	return allowed
+}
+
+// throttle applies rl to content (one or more newline-terminated
+// lines), passing through as many lines as the bucket currently
+// allows and replacing the rest with a single synthetic
+// "N lines suppressed" marker, rather than flooding the console.
+func (rl *rateLimiter) throttle(content string) string {
+	if content == "" {
+		return content
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	// SplitAfter leaves a trailing "" element when content ends in \n
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	allowed := rl.allow(len(lines))
+	if allowed >= len(lines) {
+		return content
+	}
+
+	suppressed := len(lines) - allowed
+	var b strings.Builder
+	for _, l := range lines[:allowed] {
+		b.WriteString(l)
+	}
+	b.WriteString(fmt.Sprintf("--- %d lines suppressed (rate limit) ---\n", suppressed))
+
+	return b.String()
+}
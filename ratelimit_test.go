@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRateLimiter_Throttle(t *testing.T) {
+	rl := newRateLimiter(1000, 3)
+
+	content := "one\ntwo\nthree\nfour\nfive\n"
+	out := rl.throttle(content)
+
+	if !strings.Contains(out, "one\n") || !strings.Contains(out, "three\n") {
+		t.Fatalf("expected first 3 lines to pass through, got %q", out)
+	}
+	if strings.Contains(out, "four\n") || strings.Contains(out, "five\n") {
+		t.Fatalf("expected lines beyond burst to be suppressed, got %q", out)
+	}
+	if !strings.Contains(out, "2 lines suppressed") {
+		t.Fatalf("expected a suppression marker, got %q", out)
+	}
+}
+
+func TestRateLimiter_WithinBurst(t *testing.T) {
+	rl := newRateLimiter(1000, 10)
+
+	content := "one\ntwo\n"
+	out := rl.throttle(content)
+
+	if out != content {
+		t.Fatalf("content within burst should pass through unchanged, got %q", out)
+	}
+}
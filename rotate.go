@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// followMode controls what happens when the tailed file disappears or
+// is renamed out from under us.
+type followMode int
+
+const (
+	// followDescriptor matches plain `tail -f`: once the watched path
+	// is removed or renamed, we stop following it.
+	followDescriptor followMode = iota
+	// followName matches `tail -F`: we keep retrying the path with a
+	// bounded exponential backoff and resume tailing once a file
+	// reappears there, covering logrotate's "rename" and "create"
+	// modes.
+	followName
+)
+
+// parseFollowMode parses a --follow=<mode> value.
+func parseFollowMode(s string) (followMode, error) {
+	switch s {
+	case "descriptor", "":
+		return followDescriptor, nil
+	case "name":
+		return followName, nil
+	default:
+		return followDescriptor, fmt.Errorf("unknown --follow mode: %s", s)
+	}
+}
+
+// reopenByName polls for path to reappear, backing off exponentially
+// between stat attempts, and opens it once it does. It gives up and
+// returns an error once deadline has elapsed since the file was first
+// found missing. This is what makes --follow=name survive both
+// logrotate's "rename" mode (old path moved aside, new file created)
+// and its "create" mode (old path unlinked, new file created).
+func reopenByName(path string, deadline time.Duration) (*os.File, error) {
+	b := newBackoff(50*time.Millisecond, 5*time.Second)
+	start := time.Now()
+
+	for {
+		if f, err := os.Open(path); err == nil {
+			return f, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if time.Since(start) >= deadline {
+			return nil, fmt.Errorf("%s did not reappear within %s", path, deadline)
+		}
+
+		time.Sleep(b.next())
+	}
+}
+
+// handleWrite reads the new content written to f since lastFSize,
+// covering a plain append (size grew), logrotate's copytruncate mode
+// (size shrank, so we reread from the start), and a copytruncate
+// rewrite that happens to land on exactly the same size as before
+// (same size alone isn't enough to call it a no-op: lastModTime lets
+// us tell a genuine duplicate wake-up from new content of identical
+// length).
+// Returns the content read and the file's size and mtime at the time
+// of read.
+func handleWrite(f *os.File, lastFSize int64, lastModTime time.Time) (string, int64, time.Time, error) {
+	finfo, err := os.Stat(f.Name())
+	if err != nil {
+		return "", lastFSize, lastModTime, err
+	}
+
+	switch {
+	case finfo.Size() > lastFSize:
+		debug("FILE WRITTEN")
+	case finfo.Size() < lastFSize:
+		debug("FILE TRUNCATED")
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", lastFSize, lastModTime, err
+		}
+	case finfo.ModTime().After(lastModTime):
+		debug("FILE REWRITTEN AT SAME SIZE")
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", lastFSize, lastModTime, err
+		}
+	default:
+		return "", lastFSize, lastModTime, nil
+	}
+
+	content, rsize := readContentToEOF(f)
+	return content, rsize, finfo.ModTime(), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleWrite_Append(t *testing.T) {
+	f, path := mustTempFile(t, "hello\n")
+	defer f.Close()
+
+	content, size, _, err := handleWrite(f, 0, time.Time{})
+	if err != nil {
+		t.Fatalf("handleWrite: %v", err)
+	}
+	if content != "hello\n" {
+		t.Fatalf("content = %q, want %q", content, "hello\n")
+	}
+	if size != int64(len("hello\n")) {
+		t.Fatalf("size = %d, want %d", size, len("hello\n"))
+	}
+	_ = path
+}
+
+func TestHandleWrite_CopyTruncate(t *testing.T) {
+	f, path := mustTempFile(t, "aaaaaaaaaa\n")
+	defer f.Close()
+
+	// simulate logrotate's copytruncate: file is truncated in place
+	// and new, shorter content is written
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("new\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	content, size, _, err := handleWrite(f, 11, time.Time{})
+	if err != nil {
+		t.Fatalf("handleWrite: %v", err)
+	}
+	if content != "new\n" {
+		t.Fatalf("content = %q, want %q", content, "new\n")
+	}
+	if size != int64(len("new\n")) {
+		t.Fatalf("size = %d, want %d", size, len("new\n"))
+	}
+}
+
+func TestHandleWrite_CopyTruncateSameSize(t *testing.T) {
+	f, path := mustTempFile(t, "aaaaaaaaaa\n")
+	defer f.Close()
+
+	lastFSize := int64(11)
+	finfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	lastModTime := finfo.ModTime()
+
+	// simulate a copytruncate rewrite that happens to land on exactly
+	// the same size as before: size alone can't tell this apart from
+	// a spurious wake-up with nothing new to read
+	time.Sleep(20 * time.Millisecond)
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("bbbbbbbbbb\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	content, size, _, err := handleWrite(f, lastFSize, lastModTime)
+	if err != nil {
+		t.Fatalf("handleWrite: %v", err)
+	}
+	if content != "bbbbbbbbbb\n" {
+		t.Fatalf("content = %q, want %q", content, "bbbbbbbbbb\n")
+	}
+	if size != lastFSize {
+		t.Fatalf("size = %d, want %d", size, lastFSize)
+	}
+}
+
+func TestReopenByName_Rename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = os.WriteFile(path, []byte("after rename\n"), 0644)
+	}()
+
+	f, err := reopenByName(path, time.Second)
+	if err != nil {
+		t.Fatalf("reopenByName: %v", err)
+	}
+	defer f.Close()
+}
+
+func TestReopenByName_Create(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	// logrotate's "create" mode: old file unlinked, new file created
+	// at the same path shortly after
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f, err := os.Create(path)
+		if err == nil {
+			_ = f.Close()
+		}
+	}()
+
+	f, err := reopenByName(path, time.Second)
+	if err != nil {
+		t.Fatalf("reopenByName: %v", err)
+	}
+	defer f.Close()
+}
+
+func TestReopenByName_DeadlineExceeded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "never.log")
+
+	_, err := reopenByName(path, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error once deadline elapsed, got nil")
+	}
+}
+
+func mustTempFile(t *testing.T, content string) (*os.File, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing temp file: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening temp file: %v", err)
+	}
+
+	return f, path
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +13,24 @@ import (
 	"time"
 )
 
+// defaultPollInterval is used for --poll with no explicit interval,
+// and for the automatic fallback when the native watcher backend
+// can't watch a given filesystem.
+const defaultPollInterval = time.Second
+
+// defaultReopenDeadline is how long --follow=name keeps retrying a
+// vanished file before giving up, unless overridden with
+// --reopen-timeout.
+const defaultReopenDeadline = time.Minute
+
+// defaultMaxFiles caps how many files may be tailed at once, unless
+// overridden with --max-files.
+const defaultMaxFiles = 16
+
+// defaultBurst is the rate limiter's burst capacity used with
+// --rate-limit when --burst isn't given.
+const defaultBurst = 100
+
 const (
 	// show debug information, for dev cycles
 	DEBUG_MODE = false
@@ -53,12 +69,36 @@ func main() {
 	}()
 
 	debug("processing input")
-	// watch descriptors to be closed
-	var wds []uint32
 
 	// line count to start with
 	var lcount int
 
+	// polling interval, forces the polling watcher when non-zero
+	var pollInterval time.Duration
+
+	// how we handle the tailed file disappearing or being renamed
+	follow := followDescriptor
+
+	// how long --follow=name keeps retrying a vanished file
+	reopenDeadline := defaultReopenDeadline
+
+	// whether glob patterns should also be matched recursively into
+	// subdirectories of their base directory (-R)
+	recursive := false
+
+	// cap on how many files may be tailed at once, configurable via
+	// --max-files
+	maxFiles := defaultMaxFiles
+
+	// leaky-bucket output rate limit, in lines/sec; 0 means unlimited
+	var rateLimit float64
+	// burst capacity for the rate limiter, only meaningful when
+	// rateLimit is set
+	burst := defaultBurst
+
+	// output format: text (default) or json
+	outputFormat := "text"
+
 	// args without bin name
 	if len(os.Args) == 1 {
 		printErr("no file specified to tail")
@@ -67,8 +107,9 @@ func main() {
 
 	args := os.Args[1:]
 
-	// list of files to tail
+	// list of literal files and glob patterns to tail
 	files := make([]string, 0)
+	patterns := make([]string, 0)
 
 	// parse arguments
 	for _, arg := range args {
@@ -85,12 +126,77 @@ func main() {
 				showVersionAndExit()
 			}
 
+			// is it the recursive flag, for glob patterns
+			if arg == "-R" {
+				recursive = true
+				continue
+			}
+
+			// is it the poll flag, forcing the polling watcher instead
+			// of the native OS backend
+			if arg == "--poll" || strings.HasPrefix(arg, "--poll=") {
+				pi, err := extractPollInterval(arg)
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				pollInterval = pi
+				continue
+			}
+
+			// is it the follow mode flag
+			if strings.HasPrefix(arg, "--follow=") {
+				fm, err := parseFollowMode(strings.TrimPrefix(arg, "--follow="))
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				follow = fm
+				continue
+			}
+
+			// is it the reopen timeout flag, bounding how long
+			// --follow=name retries a vanished file
+			if strings.HasPrefix(arg, "--reopen-timeout=") {
+				d, err := time.ParseDuration(strings.TrimPrefix(arg, "--reopen-timeout="))
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				reopenDeadline = d
+				continue
+			}
+
+			// is it the max files flag
+			if strings.HasPrefix(arg, "--max-files=") {
+				n, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-files="))
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				maxFiles = n
+				continue
+			}
+
+			// is it the rate limit flag
+			if strings.HasPrefix(arg, "--rate-limit=") {
+				rl, err := strconv.ParseFloat(strings.TrimPrefix(arg, "--rate-limit="), 64)
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				rateLimit = rl
+				continue
+			}
+
+			// is it the burst flag, only meaningful together with
+			// --rate-limit
+			if strings.HasPrefix(arg, "--burst=") {
+				b, err := strconv.Atoi(strings.TrimPrefix(arg, "--burst="))
+				handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
+				burst = b
+				continue
+			}
+
+			// is it the output format flag
+			if strings.HasPrefix(arg, "--output=") {
+				outputFormat = strings.TrimPrefix(arg, "--output=")
+				continue
+			}
+
 			// is it the line count flag
 			lc, err := extractLineCount(arg)
 			handleErrorAndExit(err, fmt.Sprintf("unknown flag: %s", arg))
 
 			// it is the line count flag
 			lcount = lc
+		} else if IsGlob(arg) {
+			patterns = append(patterns, arg)
 		} else {
 			// should be either a single file name, multiple filenames or a file pattern
 			// shadowing, so using f to temp store string value
@@ -104,17 +210,35 @@ func main() {
 		}
 	}
 
-	// if there are no files to tail, exit
-	if len(files) == 0 {
+	// if there's nothing to tail, exit
+	if len(files) == 0 && len(patterns) == 0 {
 		handleErrorAndExit(errors.New("no files provided to tail"), "")
 	}
 
+	// multiple sources, or a pattern that could match more than one
+	// file, means every line gets its filename prefix
+	multiName := len(files) > 1 || len(patterns) > 0
+
+	// expand glob patterns against the files present right now; files
+	// created later that match are discovered dynamically via the
+	// watched directory's events
+	validators := make([]*Validator, 0, len(patterns))
+	for _, pattern := range patterns {
+		matches, v, err := discoverGlob(pattern, recursive)
+		handleErrorAndExit(err, fmt.Sprintf("error while expanding pattern: %s", pattern))
+		files = append(files, matches...)
+		validators = append(validators, v)
+	}
+
+	if len(files) == 0 {
+		handleErrorAndExit(errors.New("no files matched"), "")
+	}
+
 	debug(fmt.Sprintf("%d files to tail", len(files)))
 
-	// limit number of files to 5 to reduce clutter
-	if len(files) > 5 {
+	if len(files) > maxFiles {
 		handleErrorAndExit(errors.New("too many files to tail"),
-			"max file limit is 5, would be too much information for ya")
+			fmt.Sprintf("max file limit is %d, would be too much information for ya", maxFiles))
 	}
 
 	// if not tail count is provided, set default tail count to 5,
@@ -123,212 +247,78 @@ func main() {
 		lcount = 5
 	}
 
-	debug("creating inotify event")
-	// TODO: apparently syscall is deprecated, use sys pkg later
-	// TODO: check if fd opened below needs to be closed
-	fd, err := syscall.InotifyInit()
-	handleErrorAndExit(err, "error while inotify init")
-
-	// schedule open wds to be closed during shutdown
-	defer func(wds []uint32, fd int) {
-		debug("defer 2: wd closings")
-		for _, wd := range wds {
-			_, _ = removeWatch(fd, wd)
+	fmtr, err := parseFormatter(outputFormat, multiName)
+	handleErrorAndExit(err, fmt.Sprintf("unknown --output format: %s", outputFormat))
+
+	debug("creating watcher")
+	watcher, err := newWatcher(files[0], pollInterval)
+	handleErrorAndExit(err, "error while creating watcher")
+
+	// schedule the watcher to be closed during shutdown
+	defer func(w Watcher) {
+		debug("defer: watcher closing")
+		_ = w.Close()
+	}(watcher)
+
+	reg := newRegistry(maxFiles)
+
+	// spawn wires up a watch and a tailer goroutine for one file,
+	// reused for both the files given on the command line and files
+	// discovered later under a watched glob directory
+	spawn := func(fname string) {
+		ch, ok := reg.register(fname)
+		if !ok {
+			debug(fmt.Sprintf("not tailing %s, already tracked or at --max-files limit", fname))
+			return
 		}
-	}(wds, fd)
 
-	// this channel communicates the events
-	events := make(chan syscall.InotifyEvent)
+		if err := watcher.Add(fname); err != nil {
+			printErr(fmt.Sprintf("error while adding a watch: %s: %s", fname, err))
+			return
+		}
 
-	// for each filename given,
-	// 1. register an inotify watch
-	// 2. spawn an inotify event watcher
-	// 3. spawn an event consumer
-	// todo: decide color for each file
-	for _, fname := range files {
-		debug(fmt.Sprintf("registering tailer for %s", fname))
-		// create file handler for file
-		f, err := os.Open(fname)
-		handleErrorAndExit(err, fmt.Sprintf("error while opening file: %s", fname))
-
-		// close the handler later
-		defer func(f *os.File) {
-			debug("defer 1: file closing")
-			if f != nil {
-				_ = f.Close()
-			}
-		}(f)
+		var rl *rateLimiter
+		if rateLimit > 0 {
+			rl = newRateLimiter(rateLimit, burst)
+		}
 
-		wd := watchFile(fd, f.Name())
-		wds = append(wds, wd)
+		debug(fmt.Sprintf("registering tailer for %s", fname))
+		go tailFile(watcher, ch, fname, lcount, fmtr, follow, reopenDeadline, rl, done)
+	}
 
-		// if a line count is provided, rewind cursor
-		// the file should be read from the end, backwards
-		debug("tailing last lines")
-		seekBackwardsByLineCount(lcount, f)
-		// read from the rewinded position to EOF
-		content, lastFSize := readContentToEOF(f)
-		// cursor is at EOF-1
+	for _, fname := range files {
+		spawn(fname)
+	}
 
-		if len(files) > 1 {
-			printContentWithFileName(f.Name(), content)
-		} else {
-			printContent(content)
+	// watch every glob pattern's base directory so files created
+	// afterwards and matching the pattern get tailed automatically. A
+	// "**" pattern additionally watches every subdirectory that
+	// already exists, since the watcher backends don't recurse on
+	// their own.
+	for _, v := range validators {
+		if err := watcher.Add(v.Base()); err != nil {
+			printErr(fmt.Sprintf("error while watching %s for new files: %s", v.Base(), err))
+			continue
 		}
-
-		// start producer loop
-		go checkInotifyEvents(fd, events)
-
-		// start consumer loop
-		go func(cwd uint32, lastFSize int64) {
-			// the cwd currently watching events for
-			// not interested in other events
-
-		ConLoop:
-			for {
-				select {
-				case <-done:
-					debug("received notice to shutdown")
-					break ConLoop
-				case event := <-events:
-					// is this an event for the file we are currently
-					// interested in?
-					if uint32(event.Wd) == cwd {
-						switch event.Mask {
-						case syscall.IN_MOVE_SELF:
-							// file moved, close current file handler and
-							// open a new one
-							debug("FILE MOVED")
-
-							// close the file now to avoid accumulating open
-							// file handlers
-							_ = f.Close()
-
-							// wait for new file to appear
-							for {
-								_, err := os.Stat(f.Name())
-								if err == nil {
-									break
-								}
-
-								debug("file not yet appeared")
-
-								// todo exponential backoff, give up after a certain time
-								// there is a window to miss some events,
-								// during timeout if the file is created and
-								// written to, we miss those events
-								// those possible writes are covered by the
-								// readContentToEOF() done later after creating
-								// a new wd
-								time.Sleep(10 * time.Second)
-							}
-
-							// file appeared, open a new file handler
-							f, err = os.Open(f.Name())
-							handleErrorAndExit(err, fmt.Sprintf("error while opening new file: %s", f.Name()))
-
-							// close the handler later
-							// can't close early within loop because next
-							// iterations need this ref survived to show
-							// content
-							defer func(f *os.File) {
-								debug("defer 3: new file closing")
-								if f != nil {
-									_ = f.Close()
-								}
-							}(f)
-
-							// add a new watch
-							nwd := watchFile(fd, f.Name())
-							// mark it to be closed during shutdown
-							wds = append(wds, nwd)
-							// set current wd to the new wd
-							cwd = nwd
-
-							// show any content created during the timeout
-							// also reset last read file size
-							content, rsize := readContentToEOF(f)
-							lastFSize = rsize
-							if len(files) > 1 {
-								printContentWithFileName(f.Name(), content)
-							} else {
-								printContent(content)
-							}
-
-							// remove existing inotify watch
-							_, _ = removeWatch(fd, wd)
-						case syscall.IN_MODIFY:
-							// file was written to or truncated, need to determine what happened
-							finfo, err := os.Stat(f.Name())
-							handleErrorAndExit(err, "error while sizing file during modify event")
-
-							if finfo.Size() > lastFSize {
-								debug("FILE WRITTEN")
-
-								// file has been written into, ie "write()"
-								content, rsize := readContentToEOF(f)
-								lastFSize = rsize
-								if len(files) > 1 {
-									printContentWithFileName(f.Name(), content)
-								} else {
-									printContent(content)
-								}
-							} else if finfo.Size() < lastFSize {
-								debug("FILE TRUNCATED")
-
-								// file has been truncated, go to the beginning
-								_, _ = f.Seek(0, io.SeekStart)
-								content, rsize := readContentToEOF(f)
-								lastFSize = rsize
-								if len(files) > 1 {
-									printContentWithFileName(f.Name(), content)
-								} else {
-									printContent(content)
-								}
-							}
-						case syscall.IN_ATTRIB:
-							debug(fmt.Sprintf("ATTRIB received: %d", event.Wd))
-
-							// rm sends an IN_ATTRIB possibly because of unlink()
-							// check if file deleted and not any other
-							// IN_ATTRIB source
-							_, err := os.Stat(f.Name())
-							if err != nil {
-								debug("FILE DELETED, TIME TO DIE")
-								// let defers be executed. os.Exit() would not allow that
-								break ConLoop
-							}
-						case syscall.IN_DELETE_SELF, syscall.IN_IGNORED, syscall.IN_UNMOUNT:
-							debug("FILE DELETED, IGNORED, OR UNMOUNTED, TIME TO DIE")
-
-							// file was deleted, exit
-							_ = f.Close()
-							// let defers be executed. os.Exit() would not allow that
-							break ConLoop
-						}
-					}
-				}
-			}
-		}(wd, lastFSize)
+		if v.Recursive() {
+			watchSubdirs(watcher, v.Base())
+		}
+		reg.watchDir(v)
 	}
 
+	go reg.dispatch(watcher, spawn, done)
+
 	// holding the main thread until shutdown
 	<-done
 	debug("received notice to shutdown")
 }
 
-// parseFileName accepts a string argument and checks to see if the
-// file with the absolute path exists or not
+// parseFileName accepts a literal (non-glob) string argument and
+// checks to see if the file with the absolute path exists or not.
+// Arguments containing wildcards are handled separately, by IsGlob
+// and discoverGlob.
 // Returns the absoulte filename and an error if the file doesn't exist
 func parseFileName(s string) (string, error) {
-	// todo: expand by wildcards,
-	//  ? - any single char
-	//  * - any multiple chars
-	//  [] - list or range of chars
-	//  {} - wildcard or exact name terms
-	//  [!] - not []
-	//  \ - escape
-	//  NOTE: not urgent, can work with tools like find
 	fname, err := filepath.Abs(s)
 	handleErrorAndExit(err, "e	rror while converting filenames")
 
@@ -356,18 +346,6 @@ func printContent(s string) {
 	_, _ = fmt.Fprint(os.Stdout, s)
 }
 
-// printContentWithFileName prints the given content to stdout,
-// prefixing each line with the base name of the given filename
-func printContentWithFileName(fname, content string) {
-	debug(fmt.Sprintf("printing line for %s", fname))
-	lines := strings.Split(strings.Trim(content, "\n"), "\n")
-	for _, l := range lines {
-		bfn := filepath.Base(fname)
-		bbfn := fmt.Sprintf("\x1b[1m%s => \x1b[0m", bfn)
-		printContent(fmt.Sprintf("%s %s\n", bbfn, l))
-	}
-}
-
 // printErr prints the given message to stderr
 func printErr(s string) {
 	_, _ = fmt.Fprintf(os.Stderr, "%s\n", s)
@@ -381,31 +359,6 @@ func debug(s string) {
 	}
 }
 
-// removeWatch stops watching a file by removing a given watch
-// descriptor from the given inotify file descriptor
-func removeWatch(fd int, wd uint32) (int, error) {
-	debug(fmt.Sprintf("removing watch: %d", wd))
-	return syscall.InotifyRmWatch(fd, wd)
-}
-
-// watchFile adds a new inotify watch for a given file at the given
-// inotify file descriptor.
-// Returns the created watch descriptor
-func watchFile(fd int, fname string) uint32 {
-	debug("adding watch")
-	wd, err := syscall.InotifyAddWatch(
-		fd,
-		fname,
-		syscall.IN_MOVE_SELF|syscall.IN_DELETE_SELF|syscall.IN_ATTRIB|
-			syscall.IN_MODIFY|syscall.IN_UNMOUNT|syscall.IN_IGNORED)
-	//syscall.IN_ALL_EVENTS)
-	handleErrorAndExit(err, fmt.Sprintf("error while adding an inotify watch: %s", fname))
-
-	uwd := uint32(wd)
-	debug(fmt.Sprintf("wd for watched file: %d", uwd))
-	return uwd
-}
-
 // handleErrorAndExit will exit with 1 if there is an error
 // todo: crude
 func handleErrorAndExit(e error, msg string) {
@@ -420,53 +373,6 @@ func handleErrorAndExit(e error, msg string) {
 	}
 }
 
-// checkInotifyEvents runs an infinite loop reading the given inotify
-// file descriptor. The read() syscall is a blocking one until any data
-// is present. Once the inotify events are present, the events are
-// unmarshalled and the event mask is communicated to the consumer
-// At the moment, the read() call could close improperly if the main
-// thread gives out. Need a way to timeout based on a notification
-// from the main thread.
-func checkInotifyEvents(fd int, events chan<- syscall.InotifyEvent) {
-	for {
-		buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*10)
-
-		// read from the opened inotify file descriptor, into buf
-		// read() is blocking until some data is available
-		debug("reading inotify event list")
-		n, err := syscall.Read(fd, buf)
-		handleErrorAndExit(err, "error while reading inotify file")
-
-		// check if the read value is 0
-		if n <= 0 {
-			printErr("inotify read resulted in EOF")
-		}
-
-		// read the buffer for all its events
-		offset := 0
-		for {
-			if offset+syscall.SizeofInotifyEvent > n {
-				debug("reached end of inotify buffer")
-				break
-			}
-
-			// unmarshal to struct
-			var event syscall.InotifyEvent
-			err = binary.Read(bytes.NewReader(buf[offset:(offset+syscall.SizeofInotifyEvent+1)]), binary.LittleEndian, &event)
-			handleErrorAndExit(err, "error while reading inotify events from the buf")
-
-			debug(fmt.Sprintf("read inotify event for wd %d", event.Wd))
-
-			// notify the waiting consumer of the event
-			// TODO buffer and gather all modify events to one to avoid spamming the consumer thread
-			events <- event
-
-			// move the window and read the next event
-			offset += syscall.SizeofInotifyEvent + int(event.Len)
-		}
-	}
-}
-
 // seekBackwardsByLineCount will move the read position of the passed
 // file until the specified line count from end is met
 // Returns the os.File reference which has a rewound cursor
@@ -486,7 +392,7 @@ func seekBackwardsByLineCount(lc int, f *os.File) {
 
 	if fsize == 0 {
 		debug("file has no content to show")
-		//return 0
+		return
 	}
 
 	// loop until lc is passed
@@ -583,3 +489,24 @@ func extractLineCount(s string) (int, error) {
 		return int(i), nil
 	}
 }
+
+// extractPollInterval parses a --poll or --poll=<interval> argument.
+// With no interval given, defaultPollInterval is used; otherwise the
+// value is parsed with time.ParseDuration (e.g. "500ms", "2s").
+func extractPollInterval(s string) (time.Duration, error) {
+	if s == "--poll" {
+		return defaultPollInterval, nil
+	}
+
+	v := strings.TrimPrefix(s, "--poll=")
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, errors.New("poll interval must be positive")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Op describes the kind of change observed for a watched file,
+// independent of the backend (inotify, kqueue, ReadDirectoryChangesW,
+// FEN, or the polling fallback) that detected it.
+type Op int
+
+const (
+	Write Op = iota
+	Rename
+	Remove
+	Chmod
+	Create
+)
+
+func (op Op) String() string {
+	switch op {
+	case Write:
+		return "WRITE"
+	case Rename:
+		return "RENAME"
+	case Remove:
+		return "REMOVE"
+	case Chmod:
+		return "CHMOD"
+	case Create:
+		return "CREATE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Event is a backend-agnostic notification that something happened to
+// a watched path.
+type Event struct {
+	Name string
+	Op   Op
+}
+
+// Watcher is the abstraction every backend (fsnotify-backed or the
+// polling fallback) implements so the consumer loop in main never has
+// to know which platform primitive is behind it.
+type Watcher interface {
+	// Add starts watching the given path.
+	Add(path string) error
+	// Remove stops watching the given path.
+	Remove(path string) error
+	// Events returns the channel Event values are delivered on.
+	Events() <-chan Event
+	// Errors returns the channel backend errors are delivered on.
+	Errors() <-chan error
+	// Close releases any resources held by the watcher.
+	Close() error
+}
+
+// newWatcher picks a backend for this run: the polling watcher if
+// pollInterval was requested via --poll, otherwise the native
+// fsnotify-backed watcher, falling back to polling automatically when
+// the native backend can't watch probe's filesystem (inotify_add_watch
+// returns ENOSYS/EINVAL on NFS, SMB, FUSE, and inside some containers).
+func newWatcher(probe string, pollInterval time.Duration) (Watcher, error) {
+	if pollInterval > 0 {
+		debug("using polling watcher (--poll)")
+		return newPollingWatcher(pollInterval), nil
+	}
+
+	fw, err := newFsnotifyWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fw.Add(probe); err != nil {
+		if isUnsupportedWatch(err) {
+			debug(fmt.Sprintf("native watcher unsupported for %s, falling back to polling", probe))
+			_ = fw.Close()
+			return newPollingWatcher(defaultPollInterval), nil
+		}
+		_ = fw.Close()
+		return nil, err
+	}
+
+	return fw, nil
+}
+
+// isUnsupportedWatch reports whether err indicates the native watcher
+// backend simply can't watch this path's filesystem, as opposed to
+// some other, fatal error.
+func isUnsupportedWatch(err error) bool {
+	return errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL)
+}
+
+// fsnotifyWatcher is a Watcher backed by github.com/fsnotify/fsnotify,
+// which in turn wraps inotify on Linux, FSEvents/kqueue on macOS/BSD,
+// and ReadDirectoryChangesW on Windows.
+type fsnotifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan Event
+	errors chan error
+}
+
+// newFsnotifyWatcher creates a Watcher backed by the OS-native fsnotify
+// implementation and starts translating its events in the background.
+func newFsnotifyWatcher() (*fsnotifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &fsnotifyWatcher{
+		w:      w,
+		events: make(chan Event),
+		errors: make(chan error),
+	}
+
+	go fw.run()
+
+	return fw, nil
+}
+
+func (fw *fsnotifyWatcher) Add(path string) error {
+	return fw.w.Add(path)
+}
+
+func (fw *fsnotifyWatcher) Remove(path string) error {
+	return fw.w.Remove(path)
+}
+
+func (fw *fsnotifyWatcher) Events() <-chan Event {
+	return fw.events
+}
+
+func (fw *fsnotifyWatcher) Errors() <-chan error {
+	return fw.errors
+}
+
+func (fw *fsnotifyWatcher) Close() error {
+	return fw.w.Close()
+}
+
+// run translates fsnotify events/errors into our backend-agnostic
+// Event/error channels until the underlying watcher is closed.
+func (fw *fsnotifyWatcher) run() {
+	for {
+		select {
+		case ev, ok := <-fw.w.Events:
+			if !ok {
+				close(fw.events)
+				return
+			}
+			fw.events <- Event{Name: ev.Name, Op: translateOp(ev.Op)}
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				close(fw.errors)
+				return
+			}
+			fw.errors <- err
+		}
+	}
+}
+
+// translateOp maps an fsnotify.Op (which may have several bits set) to
+// a single backend-agnostic Op, preferring the bit that best matches
+// GNU tail's notion of what happened to the file.
+func translateOp(op fsnotify.Op) Op {
+	switch {
+	case op&fsnotify.Remove != 0:
+		return Remove
+	case op&fsnotify.Rename != 0:
+		return Rename
+	case op&fsnotify.Write != 0:
+		return Write
+	case op&fsnotify.Chmod != 0:
+		return Chmod
+	case op&fsnotify.Create != 0:
+		return Create
+	default:
+		return Write
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// missingFileDeadline bounds how long the polling watcher will keep
+// backing off and retrying a vanished file before concluding it is
+// really gone (as opposed to mid log-rotation) and emitting Remove.
+const missingFileDeadline = 30 * time.Second
+
+// polledFile is the last known state of a path the pollingWatcher is
+// tracking.
+type polledFile struct {
+	fi      os.FileInfo
+	backoff *backoff
+
+	missing      bool
+	missingSince time.Time
+	nextCheck    time.Time
+}
+
+// pollingWatcher implements Watcher by periodically os.Stat-ing each
+// watched path instead of relying on OS change notifications. It's
+// selected via --poll, or automatically when the native backend can't
+// watch a given filesystem (common on NFS, SMB, FUSE, and inside some
+// containers).
+type pollingWatcher struct {
+	interval time.Duration
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+
+	mu      sync.Mutex
+	tracked map[string]*polledFile
+}
+
+// newPollingWatcher creates a Watcher that stats every tracked path
+// once per interval and starts its polling loop.
+func newPollingWatcher(interval time.Duration) *pollingWatcher {
+	pw := &pollingWatcher{
+		interval: interval,
+		events:   make(chan Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		tracked:  make(map[string]*polledFile),
+	}
+
+	go pw.run()
+
+	return pw
+}
+
+func (pw *pollingWatcher) Add(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	pw.mu.Lock()
+	pw.tracked[path] = &polledFile{
+		fi:      fi,
+		backoff: newBackoff(pw.interval, missingFileDeadline),
+	}
+	pw.mu.Unlock()
+
+	return nil
+}
+
+func (pw *pollingWatcher) Remove(path string) error {
+	pw.mu.Lock()
+	delete(pw.tracked, path)
+	pw.mu.Unlock()
+	return nil
+}
+
+func (pw *pollingWatcher) Events() <-chan Event {
+	return pw.events
+}
+
+func (pw *pollingWatcher) Errors() <-chan error {
+	return pw.errors
+}
+
+func (pw *pollingWatcher) Close() error {
+	close(pw.done)
+	return nil
+}
+
+func (pw *pollingWatcher) run() {
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.done:
+			return
+		case <-ticker.C:
+			pw.pollAll()
+		}
+	}
+}
+
+func (pw *pollingWatcher) pollAll() {
+	pw.mu.Lock()
+	paths := make([]string, 0, len(pw.tracked))
+	for p := range pw.tracked {
+		paths = append(paths, p)
+	}
+	pw.mu.Unlock()
+
+	now := time.Now()
+	for _, path := range paths {
+		pw.pollOne(path, now)
+	}
+}
+
+// pollOne stats a single tracked path and emits an Event if something
+// changed since the last check.
+func (pw *pollingWatcher) pollOne(path string, now time.Time) {
+	pw.mu.Lock()
+	pf, ok := pw.tracked[path]
+	pw.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if pf.missing && now.Before(pf.nextCheck) {
+		// still backing off, don't stat again yet
+		return
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			pw.handleMissing(path, pf, now)
+			return
+		}
+		pw.errors <- err
+		return
+	}
+
+	if pf.missing {
+		debug(fmt.Sprintf("%s reappeared after being missing", path))
+		pf.missing = false
+		pf.backoff.reset()
+	}
+
+	if pf.fi != nil && !os.SameFile(pf.fi, fi) {
+		debug(fmt.Sprintf("rotation detected for %s (new file identity)", path))
+		pf.fi = fi
+		pw.events <- Event{Name: path, Op: Rename}
+		return
+	}
+
+	if pf.fi == nil || fi.Size() != pf.fi.Size() || fi.ModTime() != pf.fi.ModTime() {
+		pf.fi = fi
+		pw.events <- Event{Name: path, Op: Write}
+	}
+}
+
+// handleMissing tracks a path that has just failed to stat, backing
+// off exponentially between retries, up to missingFileDeadline before
+// concluding the file was actually removed.
+func (pw *pollingWatcher) handleMissing(path string, pf *polledFile, now time.Time) {
+	if !pf.missing {
+		pf.missing = true
+		pf.missingSince = now
+		pf.backoff.reset()
+	}
+
+	if now.Sub(pf.missingSince) >= missingFileDeadline {
+		debug(fmt.Sprintf("%s still missing after %s, giving up", path, missingFileDeadline))
+		pw.events <- Event{Name: path, Op: Remove}
+		return
+	}
+
+	pf.nextCheck = now.Add(pf.backoff.next())
+}